@@ -0,0 +1,264 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Cmd is a builder for a single command, offering the same functionality as
+// [Run], [RunWith], [Output], [OutputWith], [Exec] and their *Context
+// siblings behind a fluent API. Use [New] to create one.
+//
+// A Cmd is not safe to reuse concurrently, but a single Cmd may be configured
+// and then have Run, Output, CombinedOutput or Start called on it once.
+type Cmd struct {
+	name string
+	args []string
+
+	env    map[string]string
+	dir    string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	ctx    context.Context
+
+	verbose bool
+
+	capturedStderr boundedBuffer
+}
+
+// New returns a [Cmd] that will run cmd with the given args. As with [Exec],
+// cmd and args may reference environment variables in $FOO format and args
+// may contain file glob patterns; both are expanded when the Cmd is run.
+func New(cmd string, args ...string) *Cmd {
+	return &Cmd{
+		name:           cmd,
+		args:           args,
+		stdin:          os.Stdin,
+		capturedStderr: boundedBuffer{cap: CapturedStderrCap},
+	}
+}
+
+// Env sets the environment variables to add to (and override in) the current
+// process's environment when the command runs.
+func (c *Cmd) Env(env map[string]string) *Cmd {
+	c.env = env
+	return c
+}
+
+// Dir sets the working directory for the command. If unset, the command
+// inherits this process's working directory.
+func (c *Cmd) Dir(dir string) *Cmd {
+	c.dir = dir
+	return c
+}
+
+// Stdin sets the reader the command reads its stdin from. If unset, the
+// command reads from this process's [os.Stdin].
+func (c *Cmd) Stdin(r io.Reader) *Cmd {
+	c.stdin = r
+	return c
+}
+
+// Stdout sets the writer the command's stdout is copied to.
+func (c *Cmd) Stdout(w io.Writer) *Cmd {
+	c.stdout = w
+	return c
+}
+
+// Stderr sets the writer the command's stderr is copied to. If unset, the
+// command's stderr goes to this process's [os.Stderr].
+func (c *Cmd) Stderr(w io.Writer) *Cmd {
+	c.stderr = w
+	return c
+}
+
+// Verbose makes the command send its stdout to [os.Stdout] when no
+// [Cmd.Stdout] writer is set, the same as when mage is run with -v, and logs
+// the command before it runs.
+func (c *Cmd) Verbose() *Cmd {
+	c.verbose = true
+	return c
+}
+
+// Context arranges for the command to be started with [exec.CommandContext],
+// the same as [ExecContext]: if ctx is cancelled or its deadline expires, the
+// command is sent SIGTERM, given [GracePeriod] to exit, and then killed.
+func (c *Cmd) Context(ctx context.Context) *Cmd {
+	c.ctx = ctx
+	return c
+}
+
+// String returns the command and its (unexpanded) args, quoted the same way
+// they are logged when mage is run with -v.
+func (c *Cmd) String() string {
+	var quoted []string
+	for _, arg := range c.args {
+		quoted = append(quoted, fmt.Sprintf("%q", arg))
+	}
+	return strings.TrimSpace(c.name + " " + strings.Join(quoted, " "))
+}
+
+// Run starts the command and waits for it to exit, the same as [Exec] but
+// without reporting whether the command ran.
+func (c *Cmd) Run() error {
+	_, err := c.run()
+	return err
+}
+
+// Output is like [Run], but returns the text written to stdout instead of
+// forwarding it to whatever [Cmd.Stdout] was configured with.
+func (c *Cmd) Output() (string, error) {
+	buf := &bytes.Buffer{}
+	c.stdout = buf
+	_, err := c.run()
+	return strings.TrimSuffix(buf.String(), "\n"), err
+}
+
+// CombinedOutput is like [Output], but returns stdout and stderr interleaved
+// as they were written, the same as [exec.Cmd.CombinedOutput].
+func (c *Cmd) CombinedOutput() (string, error) {
+	buf := &bytes.Buffer{}
+	c.stdout = buf
+	c.stderr = buf
+	_, err := c.run()
+	return buf.String(), err
+}
+
+// Handle is a running command started by [Cmd.Start].
+type Handle struct {
+	cmd *exec.Cmd
+}
+
+// Wait waits for the command to exit, in the same way as [exec.Cmd.Wait].
+func (h *Handle) Wait() error {
+	return h.cmd.Wait()
+}
+
+// Signal sends sig to the running command's process.
+func (h *Handle) Signal(sig os.Signal) error {
+	return h.cmd.Process.Signal(sig)
+}
+
+// Kill causes the running command's process to exit immediately.
+func (h *Handle) Kill() error {
+	return h.cmd.Process.Kill()
+}
+
+// Start starts the command but does not wait for it to exit, returning a
+// [Handle] that can be used to wait for, signal, or kill it.
+func (c *Cmd) Start() (*Handle, error) {
+	ec, err := c.build()
+	if err != nil {
+		return nil, err
+	}
+	if err := ec.Start(); err != nil {
+		return nil, fmt.Errorf(`failed to run "%s": %v`, c.String(), err)
+	}
+	return &Handle{cmd: ec}, nil
+}
+
+// run executes the command, returning whether it ran (see [CmdRan]) and any
+// error, wrapped the same way [Exec] wraps errors.
+func (c *Cmd) run() (ran bool, err error) {
+	ec, err := c.build()
+	if err != nil {
+		return false, err
+	}
+	err = ec.Run()
+	if err == nil {
+		return true, nil
+	}
+	ran = CmdRan(err)
+	if c.ctx != nil {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			return ran, fmt.Errorf(`running "%s" was stopped: %w`, c.String(), ctxErr)
+		}
+	}
+	if ran {
+		return ran, &ExecutionError{
+			Command:  ec.Args[0],
+			Args:     ec.Args[1:],
+			ExitCode: ExitStatus(err),
+			Stderr:   c.capturedStderr.Bytes(),
+			Cause:    err,
+		}
+	}
+	return ran, fmt.Errorf(`failed to run "%s": %v`, c.String(), err)
+}
+
+// build expands env vars and globs in the command's name and args and
+// constructs the underlying [exec.Cmd], but does not start it.
+func (c *Cmd) build() (*exec.Cmd, error) {
+	expand := func(s string) string {
+		if v, ok := c.env[s]; ok {
+			return v
+		}
+		return os.Getenv(s)
+	}
+	name := os.Expand(c.name, expand)
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = os.Expand(a, expand)
+	}
+	expanded, err := expandGlob(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var ec *exec.Cmd
+	if c.ctx != nil {
+		ec = exec.CommandContext(c.ctx, name, expanded...)
+		ec.Cancel = func() error {
+			return ec.Process.Signal(syscall.SIGTERM)
+		}
+		ec.WaitDelay = GracePeriod
+	} else {
+		ec = exec.Command(name, expanded...)
+	}
+	ec.Dir = c.dir
+	ec.Env = os.Environ()
+	for k, v := range c.env {
+		ec.Env = append(ec.Env, k+"="+v)
+	}
+	ec.Stdin = c.stdin
+
+	stdout := c.stdout
+	if stdout == nil && c.verbose {
+		stdout = os.Stdout
+	}
+	ec.Stdout = stdout
+
+	stderr := c.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if stdout != nil && stdout == stderr {
+		// Writing stdout and stderr into the same destination (as
+		// [Cmd.CombinedOutput] does): hand exec.Cmd the identical writer
+		// value so it serializes the two copy goroutines itself, rather than
+		// teeing into capturedStderr and racing two writers on one buffer.
+		ec.Stderr = stderr
+	} else {
+		ec.Stderr = io.MultiWriter(&c.capturedStderr, stderr)
+	}
+
+	if mg.Verbose() || c.verbose {
+		var quoted []string
+		for _, arg := range expanded {
+			quoted = append(quoted, fmt.Sprintf("%q", arg))
+		}
+		log.Println("exec:", name, strings.Join(quoted, " "))
+	}
+
+	return ec, nil
+}
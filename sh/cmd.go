@@ -2,17 +2,24 @@ package sh
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/magefile/mage/mg"
 )
 
+// GracePeriod is how long a command started via one of the *Context functions
+// is given to exit cleanly after it is sent SIGTERM (because its context was
+// cancelled or its deadline expired) before it is sent SIGKILL. It is a
+// package variable rather than a parameter so that existing call sites don't
+// need to change if they want a different grace period.
+var GracePeriod = 5 * time.Second
+
 // RunCmd returns a function that will call [Run] with the given command. This is
 // useful for creating command aliases to make your scripts easier to read, like
 // this:
@@ -92,6 +99,75 @@ func OutputWith(env map[string]string, cmd string, args ...string) (string, erro
 	return strings.TrimSuffix(buf.String(), "\n"), err
 }
 
+// CombinedOutput is like [CombinedOutputWith], but doesn't specify any
+// environment variables.
+func CombinedOutput(cmd string, args ...string) (string, error) {
+	return CombinedOutputWith(nil, cmd, args...)
+}
+
+// CombinedOutputWith is like [OutputWith], but returns stdout and stderr
+// interleaved into a single string, in the order the command wrote them, the
+// same as [exec.Cmd.CombinedOutput]. Unlike [Output], the returned string
+// includes what the command wrote to stderr.
+func CombinedOutputWith(env map[string]string, cmd string, args ...string) (string, error) {
+	return New(cmd, args...).Env(env).CombinedOutput()
+}
+
+// RunContext is like [Run], but the command is run with the given context and
+// working directory. If ctx is cancelled or its deadline expires before the
+// command exits, the command is sent SIGTERM, given [GracePeriod] to exit, and
+// then killed.
+func RunContext(ctx context.Context, dir, cmd string, args ...string) error {
+	return RunWithContext(ctx, dir, nil, cmd, args...)
+}
+
+// RunWithContext is like [RunWith], but the command is run with the given
+// context and working directory, the same as [RunContext].
+func RunWithContext(ctx context.Context, dir string, env map[string]string, cmd string, args ...string) error {
+	var output io.Writer
+	if mg.Verbose() {
+		output = os.Stdout
+	}
+	_, err := ExecContext(ctx, dir, env, output, os.Stderr, cmd, args...)
+	return err
+}
+
+// OutputContext is like [Output], but the command is run with the given
+// context and working directory, the same as [RunContext].
+func OutputContext(ctx context.Context, dir, cmd string, args ...string) (string, error) {
+	return OutputWithContext(ctx, dir, nil, cmd, args...)
+}
+
+// OutputWithContext is like [OutputWith], but the command is run with the
+// given context and working directory, the same as [RunContext].
+func OutputWithContext(ctx context.Context, dir string, env map[string]string, cmd string, args ...string) (string, error) {
+	buf := &bytes.Buffer{}
+	_, err := ExecContext(ctx, dir, env, buf, os.Stderr, cmd, args...)
+	return strings.TrimSuffix(buf.String(), "\n"), err
+}
+
+// ExecContext is like [Exec], but the command is run with the given context
+// and working directory.
+//
+// If ctx is non-nil, the command is started with [exec.CommandContext], so
+// that a Ctrl-C or the context's deadline causes the command to be sent
+// SIGTERM. The command is given [GracePeriod] to exit before it is sent
+// SIGKILL. If the command was stopped this way, the returned error unwraps to
+// [context.DeadlineExceeded] or [context.Canceled] as appropriate, in addition
+// to satisfying the usual [CmdRan] / [ExitStatus] checks.
+//
+// dir sets the working directory for the command; if dir is empty, the
+// current process's working directory is used.
+func ExecContext(ctx context.Context, dir string, env map[string]string, stdout, stderr io.Writer, cmd string, args ...string) (ran bool, err error) {
+	c := New(cmd, args...).Env(env).Dir(dir).Context(ctx)
+	c.stdout = stdout
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	c.Stderr(stderr)
+	return c.run()
+}
+
 // Exec executes the command, piping its stdout and stderr to the given
 // writers. If the command fails, it will return an error that, if returned
 // from a target or [mg.Deps] call, will cause mage to exit with the same code as
@@ -109,53 +185,13 @@ func OutputWith(env map[string]string, cmd string, args ...string) (string, erro
 // ran reports if the command ran (rather than was not found or not executable).
 // If err == nil, ran is always true.
 func Exec(env map[string]string, stdout, stderr io.Writer, cmd string, args ...string) (ran bool, err error) {
-	expand := func(s string) string {
-		s2, ok := env[s]
-		if ok {
-			return s2
-		}
-		return os.Getenv(s)
-	}
-	cmd = os.Expand(cmd, expand)
-	for i := range args {
-		args[i] = os.Expand(args[i], expand)
-	}
-	ran, code, err := run(env, stdout, stderr, cmd, args...)
-	if err == nil {
-		return true, nil
-	}
-	if ran {
-		return ran, mg.Fatalf(code, `running "%s %s" failed with exit code %d`, cmd, strings.Join(args, " "), code)
-	}
-	return ran, fmt.Errorf(`failed to run "%s %s: %v"`, cmd, strings.Join(args, " "), err)
-}
-
-func run(env map[string]string, stdout, stderr io.Writer, cmd string, args ...string) (ran bool, code int, err error) {
-	expanded, err := expandGlob(args)
-	if err != nil {
-		return false, 0, err
-	}
-
-	c := exec.Command(cmd, expanded...)
-	c.Env = os.Environ()
-	for k, v := range env {
-		c.Env = append(c.Env, k+"="+v)
-	}
-	c.Stderr = stderr
-	c.Stdout = stdout
-	c.Stdin = os.Stdin
-
-	var quoted []string
-	for _, arg := range expanded {
-		quoted = append(quoted, fmt.Sprintf("%q", arg))
-	}
-
-	// To protect against logging from doing exec in global variables
-	if mg.Verbose() {
-		log.Println("exec:", cmd, strings.Join(quoted, " "))
+	c := New(cmd, args...).Env(env)
+	c.stdout = stdout
+	if stderr == nil {
+		stderr = io.Discard
 	}
-	err = c.Run()
-	return CmdRan(err), ExitStatus(err), err
+	c.Stderr(stderr)
+	return c.run()
 }
 
 func expandGlob(value []string) (result []string, err error) {
@@ -0,0 +1,55 @@
+package sh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CapturedStderrCap is the maximum number of bytes of stderr that every
+// [Cmd] keeps a copy of (regardless of where its stderr was also directed,
+// unless stdout and stderr go to the same writer, as with
+// [Cmd.CombinedOutput], in which case no copy is kept), so that a failing command's stderr can be attached to the returned
+// [ExecutionError] without buffering an unbounded amount of output on every
+// run. It is a package variable, the same as [GracePeriod] and
+// [QuietBufferCap], so callers can raise or lower it without changing every
+// call site.
+var CapturedStderrCap = 64 * 1024 // 64KiB
+
+// ExecutionError is returned by [Exec], [ExecContext], [Cmd.Run] and their
+// relatives when a command ran but exited with a non-zero status. Use
+// [errors.As] to recover the command, its args, its exit code and any
+// captured stderr from an error returned by one of those functions.
+//
+// ExecutionError implements ExitStatus() int, so [ExitStatus] (and mage's own
+// exit-code handling) see the same code as [ExecutionError.ExitCode].
+type ExecutionError struct {
+	// Command is the name of the command that was run.
+	Command string
+	// Args are the (expanded) arguments the command was run with.
+	Args []string
+	// ExitCode is the exit code the command returned.
+	ExitCode int
+	// Stderr is whatever the command wrote to stderr, up to
+	// [CapturedStderrCap] bytes, captured regardless of where its stderr was
+	// also directed. It is empty when stdout and stderr went to the same
+	// writer, as with [Cmd.CombinedOutput].
+	Stderr []byte
+	// Cause is the underlying error returned by running the command.
+	Cause error
+}
+
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf(`running "%s %s" failed with exit code %d: %v`,
+		e.Command, strings.Join(e.Args, " "), e.ExitCode, e.Cause)
+}
+
+// Unwrap returns the underlying error from running the command.
+func (e *ExecutionError) Unwrap() error {
+	return e.Cause
+}
+
+// ExitStatus returns e.ExitCode, so that [ExitStatus] and mage's own
+// exit-code handling report the same code this error carries.
+func (e *ExecutionError) ExitStatus() int {
+	return e.ExitCode
+}
@@ -0,0 +1,197 @@
+package sh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Stage describes a single command in a [Pipe]. It holds the same information
+// as the arguments to [Run], one stage at a time.
+type Stage struct {
+	Cmd  string
+	Args []string
+	Env  map[string]string
+}
+
+// PipelineError reports which [Stage] of a [Pipe] failed. Use [errors.As] to
+// recover it from the error returned by [Pipe] or [PipeOutput].
+type PipelineError struct {
+	// Index is the position of the failing stage in the pipeline, starting at 0.
+	Index int
+	// Stage is the command and args of the failing stage.
+	Stage Stage
+	// ExitCode is the exit code the stage's command returned, from [ExitStatus].
+	ExitCode int
+	// Err is the underlying error returned by [exec.Cmd.Wait].
+	Err error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipe stage %d (%s %s) failed with exit code %d: %v",
+		e.Index, e.Stage.Cmd, strings.Join(e.Stage.Args, " "), e.ExitCode, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// Pipe runs stages as a pipeline, connecting each stage's stdout to the next
+// stage's stdin, the same way a shell connects commands joined with "|". The
+// first stage's stdin is this process's [os.Stdin], and the last stage's
+// stdout is directed to os.Stdout if mage was run with -v, the same as [Run].
+// Every stage's stderr goes to os.Stderr.
+//
+// Like [Exec], each stage's cmd and args expand environment variables and
+// file globs.
+func Pipe(stages ...Stage) error {
+	var output io.Writer
+	if mg.Verbose() {
+		output = os.Stdout
+	}
+	return pipe(output, os.Stderr, stages...)
+}
+
+// PipeOutput is like [Pipe], but returns the text written to stdout by the
+// last stage.
+func PipeOutput(stages ...Stage) (string, error) {
+	buf := &bytes.Buffer{}
+	err := pipe(buf, os.Stderr, stages...)
+	return strings.TrimSuffix(buf.String(), "\n"), err
+}
+
+func pipe(stdout, stderr io.Writer, stages ...Stage) error {
+	if len(stages) == 0 {
+		return nil
+	}
+
+	cmds := make([]*exec.Cmd, len(stages))
+	for i, stage := range stages {
+		expand := func(s string) string {
+			s2, ok := stage.Env[s]
+			if ok {
+				return s2
+			}
+			return os.Getenv(s)
+		}
+		name := os.Expand(stage.Cmd, expand)
+		args := make([]string, len(stage.Args))
+		for j, a := range stage.Args {
+			args[j] = os.Expand(a, expand)
+		}
+		expanded, err := expandGlob(args)
+		if err != nil {
+			return err
+		}
+
+		c := exec.Command(name, expanded...)
+		c.Env = os.Environ()
+		for k, v := range stage.Env {
+			c.Env = append(c.Env, k+"="+v)
+		}
+		c.Stderr = stderr
+		cmds[i] = c
+	}
+
+	cmds[0].Stdin = os.Stdin
+	cmds[len(cmds)-1].Stdout = stdout
+
+	// Connect each stage to the next with a real OS pipe, the way a shell
+	// would, rather than an io.Pipe: exec.Cmd passes an *os.File straight
+	// through to the child, with no copying goroutine of its own. An io.Pipe
+	// would make exec.Cmd spawn such a goroutine to feed it, and that
+	// goroutine's write can block forever if a downstream stage (e.g. "head")
+	// exits without draining its stdin.
+	var pipeFiles []*os.File
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("failed to create pipe between stages %d and %d: %w", i, i+1, err)
+		}
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+		pipeFiles = append(pipeFiles, r, w)
+	}
+
+	if mg.Verbose() {
+		var names []string
+		for _, s := range stages {
+			names = append(names, fmt.Sprintf("%s %s", s.Cmd, strings.Join(s.Args, " ")))
+		}
+		log.Println("exec:", strings.Join(names, " | "))
+	}
+
+	started := 0
+	var startErr error
+	for _, c := range cmds {
+		if err := c.Start(); err != nil {
+			startErr = fmt.Errorf(`failed to start "%s %s": %v`, c.Path, strings.Join(c.Args[1:], " "), err)
+			break
+		}
+		started++
+	}
+
+	// Each started child has its own copy of the pipe descriptors by now, so
+	// close ours: otherwise a stage that already exited never sees its
+	// reader hit EOF, because our end of the pipe is still open.
+	for _, f := range pipeFiles {
+		f.Close()
+	}
+
+	if startErr != nil {
+		// Wait for whatever did start so we don't leave zombies or leaked
+		// goroutines behind, then report the start failure.
+		for i := 0; i < started; i++ {
+			cmds[i].Wait()
+		}
+		return startErr
+	}
+
+	// Wait for every stage, even after one fails, so we never leave a
+	// started process unreaped; report the first genuine failure found.
+	var pipeErr *PipelineError
+	for i, c := range cmds {
+		err := c.Wait()
+		if err == nil {
+			continue
+		}
+		if i < len(cmds)-1 && isBrokenPipeErr(err) {
+			// A non-final stage was killed by SIGPIPE because a later stage
+			// exited without draining its input (e.g. "yes | head -n 1").
+			// That's normal pipeline behavior, the same as a shell's, not a
+			// pipeline failure.
+			continue
+		}
+		if pipeErr == nil {
+			pipeErr = &PipelineError{
+				Index:    i,
+				Stage:    stages[i],
+				ExitCode: ExitStatus(err),
+				Err:      err,
+			}
+		}
+	}
+	if pipeErr != nil {
+		return pipeErr
+	}
+
+	return nil
+}
+
+// isBrokenPipeErr reports whether err is the error exec.Cmd.Wait returns
+// when a process is killed by SIGPIPE, which happens when it writes to a
+// pipe whose reader has already gone away.
+func isBrokenPipeErr(err error) bool {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return false
+	}
+	return strings.Contains(ee.Error(), "broken pipe")
+}
@@ -0,0 +1,74 @@
+package sh
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func skipIfNoUnixTools(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires unix cat/echo/false/tr/yes/head")
+	}
+}
+
+func TestPipeOutput(t *testing.T) {
+	skipIfNoUnixTools(t)
+
+	out, err := PipeOutput(
+		Stage{Cmd: "echo", Args: []string{"hello world"}},
+		Stage{Cmd: "tr", Args: []string{"a-z", "A-Z"}},
+	)
+	if err != nil {
+		t.Fatalf("PipeOutput returned error: %v", err)
+	}
+	if want := "HELLO WORLD"; out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
+
+func TestPipeFailingStageReportsIndex(t *testing.T) {
+	skipIfNoUnixTools(t)
+
+	err := Pipe(
+		Stage{Cmd: "echo", Args: []string{"hi"}},
+		Stage{Cmd: "false"},
+		Stage{Cmd: "cat"},
+	)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	var pe *PipelineError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *PipelineError, got %T: %v", err, err)
+	}
+	if pe.Index != 1 {
+		t.Fatalf("expected the failing stage to be index 1, got %d", pe.Index)
+	}
+}
+
+// TestPipeEarlyExitDownstream guards against a deadlock: if a downstream
+// stage exits without draining its stdin, the upstream stage must still be
+// able to finish writing (or be signaled) rather than block forever.
+func TestPipeEarlyExitDownstream(t *testing.T) {
+	skipIfNoUnixTools(t)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Pipe(
+			Stage{Cmd: "yes"},
+			Stage{Cmd: "head", Args: []string{"-n", "1"}},
+		)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Pipe returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Pipe deadlocked waiting on an early-exiting downstream stage")
+	}
+}
@@ -0,0 +1,75 @@
+package sh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// QuietBufferCap is the maximum number of bytes of stdout or stderr that
+// RunQuiet and RunWithQuiet will buffer per stream before discarding the
+// rest, so that a runaway command can't exhaust memory. It is a package
+// variable, the same as [GracePeriod], so callers can raise or lower it
+// without changing every call site.
+var QuietBufferCap = 1 << 20 // 1MiB
+
+// RunQuiet is like [RunWithQuiet], but doesn't specify any environment
+// variables.
+func RunQuiet(cmd string, args ...string) error {
+	return RunWithQuiet(nil, cmd, args...)
+}
+
+// RunWithQuiet runs the given command, buffering its stdout and stderr
+// instead of printing them as the command runs. If the command exits
+// successfully, the buffered output is discarded; if it fails, the buffered
+// stdout and stderr are flushed to [os.Stdout] and [os.Stderr] respectively,
+// the same destinations [RunWith] would have sent them to, so the failure can
+// still be diagnosed. Each stream is capped at [QuietBufferCap] bytes.
+//
+// RunWithQuiet expands environment variables and file globs the same way
+// [Exec] does.
+func RunWithQuiet(env map[string]string, cmd string, args ...string) error {
+	stdout := &boundedBuffer{cap: QuietBufferCap}
+	stderr := &boundedBuffer{cap: QuietBufferCap}
+
+	err := New(cmd, args...).Env(env).Stdout(stdout).Stderr(stderr).Run()
+	if err != nil {
+		if _, wErr := stdout.WriteTo(os.Stdout); wErr != nil {
+			return fmt.Errorf("writing buffered stdout: %w", wErr)
+		}
+		if _, wErr := stderr.WriteTo(os.Stderr); wErr != nil {
+			return fmt.Errorf("writing buffered stderr: %w", wErr)
+		}
+	}
+	return err
+}
+
+// boundedBuffer is a [bytes.Buffer] that silently stops growing once it has
+// buffered cap bytes, so that a command that writes an unbounded amount of
+// output can't be used to exhaust memory.
+type boundedBuffer struct {
+	buf bytes.Buffer
+	cap int
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if room := b.cap - b.buf.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.buf.Write(p[:room])
+	}
+	// Report the full length written, as a real stream would, even though the
+	// tail past cap was discarded.
+	return len(p), nil
+}
+
+func (b *boundedBuffer) WriteTo(w io.Writer) (int64, error) {
+	return b.buf.WriteTo(w)
+}
+
+// Bytes returns the buffered data collected so far (up to cap bytes).
+func (b *boundedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
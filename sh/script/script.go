@@ -0,0 +1,297 @@
+// Package script runs small, txtar-style scripts that describe a sequence of
+// shell-like steps, modeled loosely on the script engine in
+// cmd/go/internal/script. It gives Magefile authors a declarative way to
+// write cross-platform integration tests without hand-rolling [sh.Exec]
+// plumbing.
+//
+// A script is a sequence of lines, one command per line. Blank lines and
+// lines starting with "#" are ignored. A line may be prefixed with "!" to
+// require that the command fail (or, for stdout/stderr, that the pattern NOT
+// match), and with one or more "[condition]" guards that skip the line
+// unless the condition holds:
+//
+//	exec go build ./...
+//	stdout 'ok'
+//	! stderr 'FAIL'
+//	env FOO=bar
+//	cd subdir
+//	cmp got want
+//	[windows] exec cmd /c dir
+//	[exec:git] exec git status
+//
+// Supported commands are exec (runs a command and records its stdout and
+// stderr), stdout and stderr (match a regular expression against the output
+// recorded by the last exec), env (sets KEY=value pairs for later commands),
+// cd (changes the directory for later commands) and cmp (fails unless two
+// files have identical contents). The "!" prefix has effect only on exec,
+// stdout and stderr; on env, cd and cmp it is ignored. Built-in conditions are "windows",
+// "short" (true when running under "go test -short") and "exec:NAME" (true
+// when NAME is found on PATH).
+package script
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// State holds the working directory, environment and most recent captured
+// output for a running [Script]. Use [NewState] to create one.
+type State struct {
+	Dir    string
+	Env    map[string]string
+	Stdout string
+	Stderr string
+}
+
+// NewState returns a [State] rooted at dir, which must already exist. If dir
+// is empty, the current working directory is used.
+func NewState(dir string) *State {
+	return &State{Dir: dir, Env: map[string]string{}}
+}
+
+// Run parses and executes script, in order, against s. It returns the first
+// error encountered, annotated with the line number and text that caused it.
+func Run(s *State, script string) error {
+	for i, raw := range strings.Split(script, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := runLine(s, line); err != nil {
+			return fmt.Errorf("line %d: %s: %w", i+1, line, err)
+		}
+	}
+	return nil
+}
+
+// RunFile is like [Run], but reads the script from the named file.
+func RunFile(s *State, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return Run(s, string(data))
+}
+
+func runLine(s *State, line string) error {
+	for {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "[") {
+			break
+		}
+		end := strings.Index(line, "]")
+		if end < 0 {
+			return fmt.Errorf("unterminated condition: %s", line)
+		}
+		ok, err := evalCondition(strings.TrimSpace(line[1:end]))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		line = line[end+1:]
+	}
+
+	neg := false
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "!") {
+		neg = true
+		line = strings.TrimSpace(line[1:])
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "exec":
+		return doExec(s, neg, args)
+	case "stdout":
+		return doMatch(s.Stdout, neg, args)
+	case "stderr":
+		return doMatch(s.Stderr, neg, args)
+	case "env":
+		return doEnv(s, args)
+	case "cd":
+		return doCd(s, args)
+	case "cmp":
+		return doCmp(s, args)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func doExec(s *State, neg bool, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("exec: missing command")
+	}
+	var stdout, stderr bytes.Buffer
+	err := sh.New(args[0], args[1:]...).Env(s.Env).Dir(s.Dir).Stdout(&stdout).Stderr(&stderr).Run()
+	s.Stdout, s.Stderr = stdout.String(), stderr.String()
+	if neg {
+		if err == nil {
+			return fmt.Errorf("exec: unexpectedly succeeded")
+		}
+		return nil
+	}
+	return err
+}
+
+func doMatch(text string, neg bool, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("want exactly one pattern, got %d", len(args))
+	}
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		return err
+	}
+	matched := re.MatchString(text)
+	if matched == neg {
+		if neg {
+			return fmt.Errorf("unexpectedly matched %q:\n%s", args[0], text)
+		}
+		return fmt.Errorf("did not match %q:\n%s", args[0], text)
+	}
+	return nil
+}
+
+func doEnv(s *State, args []string) error {
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("env: expected KEY=value, got %q", arg)
+		}
+		s.Env[k] = v
+	}
+	return nil
+}
+
+func doCd(s *State, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cd: want exactly one directory, got %d", len(args))
+	}
+	dir := args[0]
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.Dir, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cd: %s is not a directory", dir)
+	}
+	s.Dir = dir
+	return nil
+}
+
+func doCmp(s *State, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("cmp: want exactly two files, got %d", len(args))
+	}
+	got, err := os.ReadFile(filepath.Join(s.Dir, args[0]))
+	if err != nil {
+		return err
+	}
+	want, err := os.ReadFile(filepath.Join(s.Dir, args[1]))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%s and %s differ", args[0], args[1])
+	}
+	return nil
+}
+
+func evalCondition(cond string) (bool, error) {
+	neg := false
+	if strings.HasPrefix(cond, "!") {
+		neg = true
+		cond = cond[1:]
+	}
+	ok, err := lookupCondition(cond)
+	if err != nil {
+		return false, err
+	}
+	return ok != neg, nil
+}
+
+func lookupCondition(cond string) (bool, error) {
+	switch {
+	case cond == "windows":
+		return runtime.GOOS == "windows", nil
+	case cond == "short":
+		// Read the flag directly rather than calling testing.Short(), which
+		// panics outside of a go test binary (it requires testing.Init to
+		// have run first) and would also pull "-test.*" flags into every
+		// binary that links this package. The flag only exists once the
+		// testing package has registered it, i.e. when running under
+		// "go test"; otherwise [short] is simply never true.
+		f := flag.Lookup("test.short")
+		return f != nil && f.Value.String() == "true", nil
+	case strings.HasPrefix(cond, "exec:"):
+		name := strings.TrimPrefix(cond, "exec:")
+		_, err := exec.LookPath(name)
+		return err == nil, nil
+	default:
+		return false, fmt.Errorf("unknown condition %q", cond)
+	}
+}
+
+// splitArgs splits a line into words, honoring single- and double-quoted
+// substrings the way a shell would, so that "stdout 'hello world'" is two
+// arguments, not three.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inQuote := byte(0)
+	started := false
+
+	flush := func() {
+		if started {
+			args = append(args, cur.String())
+			cur.Reset()
+			started = false
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inQuote != 0:
+			if c == inQuote {
+				inQuote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			inQuote = c
+			started = true
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			cur.WriteByte(c)
+			started = true
+		}
+	}
+	if inQuote != 0 {
+		return nil, fmt.Errorf("unterminated quote in: %s", line)
+	}
+	flush()
+	return args, nil
+}
@@ -0,0 +1,160 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{``, nil},
+		{`exec go build ./...`, []string{"exec", "go", "build", "./..."}},
+		{`stdout 'hello world'`, []string{"stdout", "hello world"}},
+		{`env FOO=bar BAZ=qux`, []string{"env", "FOO=bar", "BAZ=qux"}},
+		{`exec echo "a b" c`, []string{"exec", "echo", "a b", "c"}},
+	}
+	for _, c := range cases {
+		got, err := splitArgs(c.in)
+		if err != nil {
+			t.Fatalf("splitArgs(%q): unexpected error: %v", c.in, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitArgs(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSplitArgsUnterminatedQuote(t *testing.T) {
+	if _, err := splitArgs(`stdout 'unterminated`); err == nil {
+		t.Fatal("expected an error for an unterminated quote")
+	}
+}
+
+func TestShortConditionMatchesTestingShort(t *testing.T) {
+	// Regression test: lookupCondition("short") must not panic outside of a
+	// go test binary, and while under one (as here) must agree with
+	// testing.Short(). See the "short" case in lookupCondition.
+	ok, err := evalCondition("short")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok != testing.Short() {
+		t.Fatalf("short condition = %v, want %v", ok, testing.Short())
+	}
+
+	if err := Run(NewState(t.TempDir()), "[short] env FOO=bar"); err != nil {
+		t.Fatalf("Run with a [short] guard returned error: %v", err)
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	ok, err := evalCondition("windows")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := runtime.GOOS == "windows"; ok != want {
+		t.Fatalf("windows condition = %v, want %v", ok, want)
+	}
+
+	ok, err = evalCondition("!windows")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok == (runtime.GOOS == "windows") {
+		t.Fatalf("negated windows condition = %v, want %v", ok, runtime.GOOS != "windows")
+	}
+
+	if _, err := evalCondition("bogus"); err == nil {
+		t.Fatal("expected an error for an unknown condition")
+	}
+
+	ok, err = evalCondition("exec:go")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Skip("go not on PATH in this environment")
+	}
+
+	ok, err = evalCondition("exec:definitely-not-a-real-binary")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("exec: condition reported a nonexistent binary as found")
+	}
+}
+
+func TestRunEchoAndMatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires unix echo")
+	}
+	s := NewState(t.TempDir())
+	err := Run(s, `
+exec echo hello world
+stdout 'hello world'
+! stdout 'goodbye'
+`)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+}
+
+func TestRunConditionSkipsLine(t *testing.T) {
+	s := NewState(t.TempDir())
+	err := Run(s, `[exec:definitely-not-a-real-binary] exec definitely-not-a-real-binary`)
+	if err != nil {
+		t.Fatalf("Run returned error for a line that should have been skipped: %v", err)
+	}
+}
+
+func TestRunEnvAndCd(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewState(dir)
+	if err := Run(s, "env FOO=bar\ncd sub"); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if s.Env["FOO"] != "bar" {
+		t.Fatalf("env FOO = %q, want %q", s.Env["FOO"], "bar")
+	}
+	if want := filepath.Join(dir, "sub"); s.Dir != want {
+		t.Fatalf("cd: Dir = %q, want %q", s.Dir, want)
+	}
+}
+
+func TestRunCmp(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "got"), []byte("same"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "want"), []byte("same"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewState(dir)
+	if err := Run(s, "cmp got want"); err != nil {
+		t.Fatalf("Run returned error for identical files: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "want"), []byte("different"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(s, "cmp got want"); err == nil {
+		t.Fatal("expected an error for differing files")
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	s := NewState(t.TempDir())
+	if err := Run(s, "bogus"); err == nil {
+		t.Fatal("expected an error for an unknown command")
+	}
+}